@@ -0,0 +1,187 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/property"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type vsphereClusterMetrics []*vsphereClusterMetric
+
+type vsphereClusterMetric struct {
+	desc         *prometheus.Desc
+	metricGetter clusterMetricGetter
+	labelsGetter []clusterLabelGetter
+}
+
+type clusterMetricGetter func(mo.ClusterComputeResource) float64
+type clusterLabelGetter func(mo.ClusterComputeResource) string
+
+//Labels associated with the cluster objects
+var clusterLabelNames = []string{"name", "datacenter", "vcenter"}
+
+//Array of anonymous functions to retrieve label values
+var clusterLabelValues = []clusterLabelGetter{clusterLabelGetterFuncRegistry["getClusterName"]}
+
+//Map of anonymous functions to retrieve label values from a cluster object
+var clusterLabelGetterFuncRegistry = map[string]clusterLabelGetter{
+	"getClusterName": func(c mo.ClusterComputeResource) string { return c.Name },
+}
+
+//Map of anonymous functions to retrieve metric values
+var clusterMetricGetterFuncRegistry = map[string]clusterMetricGetter{
+	"getCPUTotal": func(c mo.ClusterComputeResource) float64 {
+		return float64(c.Summary.GetComputeResourceSummary().TotalCpu)
+	},
+	"getCPUEffective": func(c mo.ClusterComputeResource) float64 {
+		return float64(c.Summary.GetComputeResourceSummary().EffectiveCpu)
+	},
+	"getMemoryTotal": func(c mo.ClusterComputeResource) float64 {
+		return float64(c.Summary.GetComputeResourceSummary().TotalMemory)
+	},
+	"getMemoryEffective": func(c mo.ClusterComputeResource) float64 {
+		//EffectiveMemory is reported in MB, unlike TotalMemory which is already in bytes
+		return float64(c.Summary.GetComputeResourceSummary().EffectiveMemory) * 1024 * 1024
+	},
+	"getNumHosts": func(c mo.ClusterComputeResource) float64 {
+		return float64(c.Summary.GetComputeResourceSummary().NumHosts)
+	},
+	"getNumEffectiveHosts": func(c mo.ClusterComputeResource) float64 {
+		return float64(c.Summary.GetComputeResourceSummary().NumEffectiveHosts)
+	},
+	"getOverallStatusGreen": func(c mo.ClusterComputeResource) float64 {
+		if status := c.Summary.GetComputeResourceSummary().OverallStatus; status == types.ManagedEntityStatusGreen {
+			return 1
+		}
+		return 0
+	},
+	"getOverallStatusYellow": func(c mo.ClusterComputeResource) float64 {
+		if status := c.Summary.GetComputeResourceSummary().OverallStatus; status == types.ManagedEntityStatusYellow {
+			return 1
+		}
+		return 0
+	},
+	"getOverallStatusRed": func(c mo.ClusterComputeResource) float64 {
+		if status := c.Summary.GetComputeResourceSummary().OverallStatus; status == types.ManagedEntityStatusRed {
+			return 1
+		}
+		return 0
+	},
+	"getDrsEnabled": func(c mo.ClusterComputeResource) float64 {
+		if enabled := c.Configuration.DrsConfig.Enabled; enabled != nil && *enabled {
+			return 1
+		}
+		return 0
+	},
+	"getHaEnabled": func(c mo.ClusterComputeResource) float64 {
+		if enabled := c.Configuration.DasConfig.Enabled; enabled != nil && *enabled {
+			return 1
+		}
+		return 0
+	},
+	"getAdmissionControlEnabled": func(c mo.ClusterComputeResource) float64 {
+		if enabled := c.Configuration.DasConfig.AdmissionControlEnabled; enabled != nil && *enabled {
+			return 1
+		}
+		return 0
+	},
+}
+
+func newVsphereClusterMetric(name string, description string, labels []string, metricGetter clusterMetricGetter, labelsGetter []clusterLabelGetter) *vsphereClusterMetric {
+	return &vsphereClusterMetric{
+		desc:         prometheus.NewDesc(name, description, labels, nil),
+		metricGetter: metricGetter,
+		labelsGetter: labelsGetter,
+	}
+}
+
+//Labels associated with the cluster's root resource pool reservation metrics
+var clusterResourcePoolLabelNames = []string{"name", "datacenter", "vcenter"}
+
+//buildClusterMetrics defines all collected metrics for ClusterComputeResources. It is built
+//per-Exporter, rather than once at package init, so that a target's extra_labels are reflected in
+//every Desc's label set.
+func buildClusterMetrics(extraLabelKeys []string) vsphereClusterMetrics {
+	labelNames := append(append([]string{}, clusterLabelNames...), extraLabelKeys...)
+	return vsphereClusterMetrics{
+		newVsphereClusterMetric(clusterMetricPrefix+"cpu_total_mhz", "Total CPU capacity of the cluster", labelNames, clusterMetricGetterFuncRegistry["getCPUTotal"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"cpu_effective_mhz", "Effective CPU capacity of the cluster, accounting for host failover reservations", labelNames, clusterMetricGetterFuncRegistry["getCPUEffective"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"memory_total_bytes", "Total memory capacity of the cluster", labelNames, clusterMetricGetterFuncRegistry["getMemoryTotal"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"memory_effective_bytes", "Effective memory capacity of the cluster, accounting for host failover reservations", labelNames, clusterMetricGetterFuncRegistry["getMemoryEffective"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"num_hosts", "Number of hosts in the cluster", labelNames, clusterMetricGetterFuncRegistry["getNumHosts"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"num_effective_hosts", "Number of hosts in the cluster that are not in maintenance mode or otherwise unavailable", labelNames, clusterMetricGetterFuncRegistry["getNumEffectiveHosts"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"overall_status_green", "Cluster overall status is green", labelNames, clusterMetricGetterFuncRegistry["getOverallStatusGreen"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"overall_status_yellow", "Cluster overall status is yellow", labelNames, clusterMetricGetterFuncRegistry["getOverallStatusYellow"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"overall_status_red", "Cluster overall status is red", labelNames, clusterMetricGetterFuncRegistry["getOverallStatusRed"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"drs_enabled", "DRS is enabled on the cluster", labelNames, clusterMetricGetterFuncRegistry["getDrsEnabled"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"ha_enabled", "vSphere HA is enabled on the cluster", labelNames, clusterMetricGetterFuncRegistry["getHaEnabled"], clusterLabelValues),
+		newVsphereClusterMetric(clusterMetricPrefix+"admission_control_enabled", "vSphere HA admission control is enabled on the cluster", labelNames, clusterMetricGetterFuncRegistry["getAdmissionControlEnabled"], clusterLabelValues),
+	}
+}
+
+func collectClusterMetrics(wg *sync.WaitGroup, e *Exporter, f *find.Finder, datacenterName string, ch chan<- prometheus.Metric) {
+	defer wg.Done()
+	clusters, err := f.ClusterComputeResourceList(e.context, "*")
+	if err != nil {
+		log.Infoln("Could not retrieve clusters list: %s", err)
+		return
+	}
+	if len(clusters) == 0 {
+		return
+	}
+	var refs []types.ManagedObjectReference
+	for _, cluster := range clusters {
+		refs = append(refs, cluster.Reference())
+	}
+	pc := property.DefaultCollector(e.client.Client)
+	var crs []mo.ClusterComputeResource
+	if err := pc.Retrieve(e.context, refs, []string{"name", "summary", "configuration", "resourcePool"}, &crs); err != nil {
+		log.Infoln("Could not retrieve cluster properties: ", err)
+		return
+	}
+
+	var poolRefs []types.ManagedObjectReference
+	for _, cr := range crs {
+		if cr.ResourcePool != nil {
+			poolRefs = append(poolRefs, *cr.ResourcePool)
+		}
+	}
+	var pools []mo.ResourcePool
+	if len(poolRefs) > 0 {
+		if err := pc.Retrieve(e.context, poolRefs, []string{"runtime"}, &pools); err != nil {
+			log.Infoln("Could not retrieve cluster resource pool properties: ", err)
+		}
+	}
+	poolsByRef := make(map[string]mo.ResourcePool)
+	for _, pool := range pools {
+		poolsByRef[pool.Self.Value] = pool
+	}
+
+	for _, cr := range crs {
+		for _, metric := range e.clusterMetrics {
+			var labelValues []string
+			for _, labelGetter := range metric.labelsGetter {
+				labelValues = append(labelValues, labelGetter(cr))
+			}
+			labelValues = append(labelValues, datacenterName, e.config.Name)
+			labelValues = append(labelValues, e.extraLabelValues...)
+			ch <- prometheus.MustNewConstMetric(metric.desc, prometheus.GaugeValue, metric.metricGetter(cr), labelValues...)
+		}
+		if cr.ResourcePool == nil {
+			continue
+		}
+		pool, ok := poolsByRef[cr.ResourcePool.Value]
+		if !ok {
+			continue
+		}
+		reservationLabelValues := append([]string{cr.Name, datacenterName, e.config.Name}, e.extraLabelValues...)
+		ch <- prometheus.MustNewConstMetric(e.clusterCPUReservationDesc, prometheus.GaugeValue, float64(pool.Runtime.Cpu.ReservationUsed), reservationLabelValues...)
+		ch <- prometheus.MustNewConstMetric(e.clusterMemoryReservationDesc, prometheus.GaugeValue, float64(pool.Runtime.Memory.ReservationUsed), reservationLabelValues...)
+	}
+}
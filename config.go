@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+//ResourceMetricSelection lists the metric registry keys to collect per resource type. A nil list
+//means "everything in the registry", matching the exporter's behavior before metric selection existed.
+type ResourceMetricSelection struct {
+	Host      []string `yaml:"host"`
+	Datastore []string `yaml:"datastore"`
+}
+
+//VCenterConfig describes a single vCenter target. When loaded from --config.file, each list entry
+//becomes its own isolated Exporter so that one vCenter going stale can't poison the others.
+type VCenterConfig struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Insecure bool   `yaml:"insecure"`
+
+	//MetricPrefix overrides the default esxi_/datastore_ metric name prefix for this target.
+	MetricPrefix string `yaml:"metric_prefix"`
+	//Domain and RemoveHostDomainName strip a known DNS suffix from the ESXi host "name" label, so the
+	//same host isn't reported under two different names depending on whether vCenter registered it
+	//with a FQDN or a short name.
+	Domain               string `yaml:"domain"`
+	RemoveHostDomainName bool   `yaml:"remove_host_domain_name"`
+	//Metrics selects which registry keys to collect per resource type. Unknown keys fail config
+	//validation at startup rather than being silently dropped.
+	Metrics ResourceMetricSelection `yaml:"metrics"`
+	//ExtraLabels are appended as static labels to every host and datastore series scraped from this
+	//target, e.g. environment=prod, region=eu-west.
+	ExtraLabels map[string]string `yaml:"extra_labels"`
+}
+
+//Config is the top-level --config.file schema.
+type Config struct {
+	VCenters []VCenterConfig `yaml:"vcenters"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	for i, vc := range cfg.VCenters {
+		if vc.Name == "" {
+			cfg.VCenters[i].Name = vc.URL
+		}
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"path"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+//discoverDatacenters walks the inventory from the root folder down, descending through any nesting
+//of Folder objects, and returns every Datacenter found at any depth. This replaces a plain
+//find.Finder.DatacenterList("*") call, which only matches datacenters at the root folder and
+//silently misses deployments that organize datacenters inside folders. Each returned Datacenter has
+//its InventoryPath set, the same way find.Finder.DatacenterList does, since object.Common.Name()
+//(used downstream as the "datacenter" label) derives its value from that field.
+func discoverDatacenters(ctx context.Context, client *vim25.Client) ([]*object.Datacenter, error) {
+	pc := property.DefaultCollector(client)
+	var datacenters []*object.Datacenter
+
+	var visit func(ref types.ManagedObjectReference, parentPath string) error
+	visit = func(ref types.ManagedObjectReference, parentPath string) error {
+		var folder mo.Folder
+		if err := pc.RetrieveOne(ctx, ref, []string{"childEntity"}, &folder); err != nil {
+			return err
+		}
+		if len(folder.ChildEntity) == 0 {
+			return nil
+		}
+		var entities []mo.ManagedEntity
+		if err := pc.Retrieve(ctx, folder.ChildEntity, []string{"name"}, &entities); err != nil {
+			return err
+		}
+		names := make(map[string]string, len(entities))
+		for _, entity := range entities {
+			names[entity.Self.Value] = entity.Name
+		}
+		for _, child := range folder.ChildEntity {
+			childPath := path.Join(parentPath, names[child.Value])
+			switch child.Type {
+			case "Datacenter":
+				dc := object.NewDatacenter(client, child)
+				dc.SetInventoryPath(childPath)
+				datacenters = append(datacenters, dc)
+			case "Folder":
+				if err := visit(child, childPath); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := visit(client.ServiceContent.RootFolder, "/"); err != nil {
+		return nil, err
+	}
+	return datacenters, nil
+}
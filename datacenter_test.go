@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/simulator"
+)
+
+//TestDiscoverDatacentersNestedFolderAndStandaloneHost builds an inventory with a datacenter nested
+//inside a folder, plus a clustered host and a standalone host within it, and asserts that
+//discoverDatacenters finds the nested datacenter with a usable Name() (chunk0-5), and that both the
+//clustered and the standalone host are listed by a Finder scoped to that datacenter.
+func TestDiscoverDatacentersNestedFolderAndStandaloneHost(t *testing.T) {
+	model := simulator.VPX()
+	model.Folder = 1
+	model.Datacenter = 1
+	model.Cluster = 1
+	model.ClusterHost = 1
+	model.Host = 1
+	defer model.Remove()
+
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	client, err := govmomi.NewClient(ctx, server.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	datacenters, err := discoverDatacenters(ctx, client.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datacenters) != 1 {
+		t.Fatalf("expected 1 datacenter, got %d", len(datacenters))
+	}
+
+	dc := datacenters[0]
+	if dc.Name() == "" {
+		t.Errorf("datacenter Name() is empty, InventoryPath=%q", dc.InventoryPath)
+	}
+
+	f := find.NewFinder(client.Client, true)
+	f.SetDatacenter(dc)
+
+	hosts, err := f.HostSystemList(ctx, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//model.Host (standalone) + model.Cluster*model.ClusterHost (clustered) hosts should both be present
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 hosts (1 standalone + 1 clustered), got %d", len(hosts))
+	}
+}
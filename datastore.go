@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/vmware/govmomi/property"
@@ -25,7 +26,7 @@ type datastoreMetricGetter func(mo.Datastore) float64
 type datastoreLabelGetter func(mo.Datastore) string
 
 //Labels associated with the datastore objects
-var datastoreLabelNames = []string{"name", "datacenter"}
+var datastoreLabelNames = []string{"name", "datacenter", "vcenter"}
 
 //Array of anonymous functions to retrieve label values
 var datastoreLabelValues = []datastoreLabelGetter{datastoreLabelGetterFuncRegistry["getDatastoreName"]}
@@ -55,9 +56,59 @@ func newVsphereDatastoreMetric(name string, description string, labels []string,
 	}
 }
 
+//datastoreMetricDef pairs a datastoreMetricGetterFuncRegistry key with the metric name suffix and
+//description used to register it. datastoreMetricCatalog is the full, ordered set; a target's
+//`metrics.datastore` config selects a subset of it by key.
+type datastoreMetricDef struct {
+	key         string
+	name        string
+	description string
+}
+
+var datastoreMetricCatalog = []datastoreMetricDef{
+	{"getCapacity", "capacity_bytes", "Datastore capacity"},
+	{"getFreeSpace", "free_space_bytes", "Datastore free space"},
+	{"getAccessibility", "accessibility", "Datastore connectivity status"},
+}
+
+//buildDatastoreMetrics resolves a target's metric_prefix and metrics.datastore selection into the
+//concrete set of datastore metrics to collect for it. An empty selection means the full catalog.
+//Unknown keys are rejected so a typo in the config file fails at startup instead of silently
+//collecting nothing.
+func buildDatastoreMetrics(prefix string, selected []string, extraLabelKeys []string) (vsphereDatastoreMetrics, error) {
+	if prefix == "" {
+		prefix = datastoreMetricPrefix
+	}
+	defs := datastoreMetricCatalog
+	if len(selected) > 0 {
+		defs = make([]datastoreMetricDef, 0, len(selected))
+		for _, key := range selected {
+			def, ok := datastoreMetricByKey(key)
+			if !ok {
+				return nil, fmt.Errorf("unknown datastore metric %q", key)
+			}
+			defs = append(defs, def)
+		}
+	}
+	labelNames := append(append([]string{}, datastoreLabelNames...), extraLabelKeys...)
+	metrics := make(vsphereDatastoreMetrics, 0, len(defs))
+	for _, def := range defs {
+		metrics = append(metrics, newVsphereDatastoreMetric(prefix+def.name, def.description, labelNames, datastoreMetricGetterFuncRegistry[def.key], datastoreLabelValues))
+	}
+	return metrics, nil
+}
+
+func datastoreMetricByKey(key string) (datastoreMetricDef, bool) {
+	for _, def := range datastoreMetricCatalog {
+		if def.key == key {
+			return def, true
+		}
+	}
+	return datastoreMetricDef{}, false
+}
+
 func collectDatastoreMetrics(wg *sync.WaitGroup, e *Exporter, f *find.Finder, datacenterName string, ch chan<- prometheus.Metric) {
 	defer wg.Done()
-	datastoresRefList = make(map[string]string)
 	datastores, err := f.DatastoreList(e.context, "*")
 	if err != nil {
 		log.Infoln("Could not retrieve datastore list: %s", err)
@@ -67,8 +118,10 @@ func collectDatastoreMetrics(wg *sync.WaitGroup, e *Exporter, f *find.Finder, da
 		pc := property.DefaultCollector(e.client.Client)
 		var refs []types.ManagedObjectReference
 		for _, datastore := range datastores {
+			if !datastoreFilter.allowed(datastore.InventoryPath) {
+				continue
+			}
 			refs = append(refs, datastore.Reference())
-			datastoresRefList[datastore.Reference().String()] = datastore.Name()
 		}
 
 		var ds []mo.Datastore
@@ -78,12 +131,14 @@ func collectDatastoreMetrics(wg *sync.WaitGroup, e *Exporter, f *find.Finder, da
 			return
 		}
 		for _, d := range ds {
-			for _, metric := range datastoreMetrics {
+			for _, metric := range e.datastoreMetrics {
 				var labelValues []string
 				for _, labelGetter := range metric.labelsGetter {
 					labelValues = append(labelValues, labelGetter(d))
 				}
 				labelValues = append(labelValues, datacenterName)
+				labelValues = append(labelValues, e.config.Name)
+				labelValues = append(labelValues, e.extraLabelValues...)
 				ch <- prometheus.MustNewConstMetric(metric.desc, prometheus.GaugeValue, metric.metricGetter(d), labelValues...)
 			}
 		}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+//hostFilter, datastoreFilter and vmFilter scope which inventory objects are scraped, configured via
+//the --host.include/--host.exclude, --datastore.include/--datastore.exclude and --vm.include/--vm.exclude
+//repeatable flags. An empty include list means "everything", matching the exporter's current behavior.
+var (
+	hostFilter      = newInventoryFilter(nil, nil)
+	datastoreFilter = newInventoryFilter(nil, nil)
+	vmFilter        = newInventoryFilter(nil, nil)
+)
+
+type inventoryFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newInventoryFilter(includes []string, excludes []string) *inventoryFilter {
+	f := &inventoryFilter{}
+	for _, pattern := range includes {
+		f.include = append(f.include, compileInventoryPattern(pattern))
+	}
+	for _, pattern := range excludes {
+		f.exclude = append(f.exclude, compileInventoryPattern(pattern))
+	}
+	return f
+}
+
+//allowed reports whether a govmomi inventory path (e.g. "/DC-EU/host/ProdCluster/esx-01.example.com")
+//should be scraped. Exclude rules always win over include rules.
+func (f *inventoryFilter) allowed(inventoryPath string) bool {
+	for _, pattern := range f.exclude {
+		if pattern.MatchString(inventoryPath) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if pattern.MatchString(inventoryPath) {
+			return true
+		}
+	}
+	return false
+}
+
+//compileInventoryPattern turns a govmomi inventory path pattern using "*" (single path segment) and
+//"**" (any number of segments) wildcards into an anchored regexp.
+func compileInventoryPattern(pattern string) *regexp.Regexp {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			re.WriteString("[^/]*")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String())
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/vmware/govmomi/object"
@@ -26,7 +29,7 @@ type hostMetricGetter func(mo.HostSystem) float64
 type hostLabelGetter func(mo.HostSystem) string
 
 //Labels associated with the datastore objects
-var hostLabelNames = []string{"name", "datacenter", "cluster"}
+var hostLabelNames = []string{"name", "datacenter", "cluster", "vcenter"}
 
 //Array of anonymous functions to retrieve label values
 var hostLabelValues = []hostLabelGetter{hostLabelGetterFuncRegistry["getHostName"]}
@@ -74,38 +77,132 @@ func newVsphereHostMetric(name string, description string, labels []string, metr
 	}
 }
 
+//hostMetricDef pairs a hostMetricGetterFuncRegistry key with the metric name suffix and description
+//used to register it. hostMetricCatalog is the full, ordered set; a target's `metrics.host` config
+//selects a subset of it by key.
+type hostMetricDef struct {
+	key         string
+	name        string
+	description string
+}
+
+var hostMetricCatalog = []hostMetricDef{
+	{"getMemorySize", "memory_total_bytes", "Size of the esxi memory"},
+	{"getMemoryUsage", "memory_usage_bytes", "Memory usage of the ESXi host"},
+	{"getCPUTotal", "cpu_total_mhz", "Total cpu available"},
+	{"getCpuUsage", "cpu_usage_mhz", "CPU usage"},
+	{"getConnectedState", "connected_state", "Esxi host connected state"},
+	{"getDisconnectedState", "disconnected_state", "Esxi host connected state"},
+	{"getNotRespondingState", "not_responding_state", "Esxi host connected state"},
+}
+
+//newHostNameLabelGetter builds the "name" label getter for a target, stripping the configured DNS
+//domain suffix off the host name when requested. Hosts are frequently registered under an FQDN in
+//one datacenter and a short name in another; stripping the suffix lets both join on the same name.
+func newHostNameLabelGetter(domain string, removeDomain bool) hostLabelGetter {
+	return func(h mo.HostSystem) string {
+		name := h.Summary.Config.Name
+		if removeDomain && domain != "" {
+			name = strings.TrimSuffix(name, "."+domain)
+		}
+		return name
+	}
+}
+
+//buildHostMetrics resolves a target's metric_prefix and metrics.host selection into the concrete
+//set of host metrics to collect for it. An empty selection means the full catalog. Unknown keys are
+//rejected so a typo in the config file fails at startup instead of silently collecting nothing.
+func buildHostMetrics(prefix string, selected []string, nameGetter hostLabelGetter, extraLabelKeys []string) (vsphereHostMetrics, error) {
+	if prefix == "" {
+		prefix = esxiMetricPrefix
+	}
+	defs := hostMetricCatalog
+	if len(selected) > 0 {
+		defs = make([]hostMetricDef, 0, len(selected))
+		for _, key := range selected {
+			def, ok := hostMetricByKey(key)
+			if !ok {
+				return nil, fmt.Errorf("unknown host metric %q", key)
+			}
+			defs = append(defs, def)
+		}
+	}
+	labelNames := append(append([]string{}, hostLabelNames...), extraLabelKeys...)
+	labelValues := []hostLabelGetter{nameGetter}
+	metrics := make(vsphereHostMetrics, 0, len(defs))
+	for _, def := range defs {
+		metrics = append(metrics, newVsphereHostMetric(prefix+def.name, def.description, labelNames, hostMetricGetterFuncRegistry[def.key], labelValues))
+	}
+	return metrics, nil
+}
+
+func hostMetricByKey(key string) (hostMetricDef, bool) {
+	for _, def := range hostMetricCatalog {
+		if def.key == key {
+			return def, true
+		}
+	}
+	return hostMetricDef{}, false
+}
+
+//standaloneClusterName tags hosts that sit directly under a datacenter's host folder rather than
+//inside a ClusterComputeResource
+const standaloneClusterName = "standalone"
+
+//computeResourceHosts is satisfied by both *object.ComputeResource and *object.ClusterComputeResource,
+//letting clustered and standalone hosts share the same collection code path.
+type computeResourceHosts interface {
+	Hosts(ctx context.Context) ([]*object.HostSystem, error)
+}
+
 func collectHostMetrics(wg *sync.WaitGroup, e *Exporter, f *find.Finder, datacenterName string, ch chan<- prometheus.Metric) {
 	defer wg.Done()
-	hostsRefList = make(map[string]string)
 	//Retrieves the cluster list
 	clusters, err := f.ClusterComputeResourceList(e.context, "*")
 	if err != nil {
 		log.Infoln("Could not retrieve clusters list: %s", err)
 		return
 	}
-	//TODO hosts outside a cluster are not handled
-	//Retrieves the host list for each cluster
+	//Retrieves every compute resource, then keeps only the ones that aren't clusters: standalone
+	//ESXi hosts sit directly under the datacenter's host folder as a plain ComputeResource
+	computeResources, err := f.ComputeResourceList(e.context, "*")
+	if err != nil {
+		log.Infoln("Could not retrieve compute resource list: %s", err)
+		return
+	}
+	var standalone []*object.ComputeResource
+	for _, cr := range computeResources {
+		if cr.Reference().Type != "ClusterComputeResource" {
+			standalone = append(standalone, cr)
+		}
+	}
+
 	var wgClusters sync.WaitGroup
-	wgClusters.Add(len(clusters))
+	wgClusters.Add(len(clusters) + len(standalone))
 	for _, cluster := range clusters {
-		go collectHostMetricFromCluster(&wgClusters, e, datacenterName, cluster, ch)
+		go collectHostMetricFromComputeResource(&wgClusters, e, datacenterName, cluster.Name(), cluster, ch)
+	}
+	for _, cr := range standalone {
+		go collectHostMetricFromComputeResource(&wgClusters, e, datacenterName, standaloneClusterName, cr, ch)
 	}
 	wgClusters.Wait()
 }
 
-func collectHostMetricFromCluster(wg *sync.WaitGroup, e *Exporter, datacenterName string, cluster *object.ClusterComputeResource, ch chan<- prometheus.Metric) {
+func collectHostMetricFromComputeResource(wg *sync.WaitGroup, e *Exporter, datacenterName string, clusterName string, cr computeResourceHosts, ch chan<- prometheus.Metric) {
 	defer wg.Done()
-	hosts, err := cluster.Hosts(e.context)
+	hosts, err := cr.Hosts(e.context)
 	if err != nil {
 		log.Infoln("Could not retrieve host list: %s", err)
 		return
 	}
 	if len(hosts) > 0 {
-		//Gets host properties for each host reference
+		//Gets host properties for each host reference, skipping anything --host.exclude/--host.include rules out
 		var refs []types.ManagedObjectReference
 		for _, host := range hosts {
+			if !hostFilter.allowed(host.InventoryPath) {
+				continue
+			}
 			refs = append(refs, host.Reference())
-			hostsRefList[host.Reference().String()] = host.Name()
 		}
 		pc := property.DefaultCollector(e.client.Client)
 		var hs []mo.HostSystem
@@ -115,14 +212,16 @@ func collectHostMetricFromCluster(wg *sync.WaitGroup, e *Exporter, datacenterNam
 		}
 		//Push all metrics for each
 		for _, h := range hs {
-			for _, metric := range hostMetrics {
+			for _, metric := range e.hostMetrics {
 				var labelValues []string
 				for _, labelGetter := range metric.labelsGetter {
 
 					labelValues = append(labelValues, labelGetter(h))
 				}
 				labelValues = append(labelValues, datacenterName)
-				labelValues = append(labelValues, cluster.Name())
+				labelValues = append(labelValues, clusterName)
+				labelValues = append(labelValues, e.config.Name)
+				labelValues = append(labelValues, e.extraLabelValues...)
 				ch <- prometheus.MustNewConstMetric(metric.desc, prometheus.GaugeValue, metric.metricGetter(h), labelValues...)
 			}
 		}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/performance"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const perfMetricPrefix = "perf_"
+
+//perfCounters is the set of QueryPerf counters an operator has opted into via --perf.counters.
+//An empty list means the real-time performance collection path is skipped entirely, since pulling
+//it unconditionally would double the load of every scrape.
+var perfCounters []perfCounterRequest
+
+//perfCounterRequest is one parsed --perf.counters entry: the counter name, and whether to collect a
+//per-device breakdown (instance "*", the default) or a single aggregate series (instance "",
+//requested via a ":aggregate" suffix on the flag value).
+type perfCounterRequest struct {
+	name     string
+	instance string
+}
+
+//parsePerfCounterRequests turns the raw --perf.counters flag values into perfCounterRequests. A bare
+//counter name (e.g. "cpu.usage.average") collects a per-device breakdown; a ":aggregate" suffix (e.g.
+//"cpu.usage.average:aggregate") collects a single aggregate series for that counter instead.
+func parsePerfCounterRequests(raw []string) []perfCounterRequest {
+	requests := make([]perfCounterRequest, 0, len(raw))
+	for _, entry := range raw {
+		name, instance := entry, "*"
+		if trimmed := strings.TrimSuffix(entry, ":aggregate"); trimmed != entry {
+			name, instance = trimmed, ""
+		}
+		requests = append(requests, perfCounterRequest{name: name, instance: instance})
+	}
+	return requests
+}
+
+//perfCounterCache holds the PerfCounterInfo lookup for a vCenter. The counter key -> name mapping
+//never changes for a running vCenter, so it is resolved once via CounterInfoByName and reused for
+//every subsequent scrape instead of being re-fetched each time.
+type perfCounterCache struct {
+	mu     sync.Mutex
+	byName map[string]*types.PerfCounterInfo
+}
+
+func (e *Exporter) perfCounterInfo(pm *performance.Manager) (map[string]*types.PerfCounterInfo, error) {
+	e.perfCache.mu.Lock()
+	defer e.perfCache.mu.Unlock()
+	if e.perfCache.byName != nil {
+		return e.perfCache.byName, nil
+	}
+	counters, err := pm.CounterInfoByName(e.context)
+	if err != nil {
+		return nil, err
+	}
+	e.perfCache.byName = counters
+	return counters, nil
+}
+
+//collectPerfMetrics pulls the 20-second real-time samples vCenter exposes for hosts, datastores
+//and VMs, in addition to the summary-derived metrics collected elsewhere. It is a no-op unless
+//--perf.counters was used to opt in to specific counters.
+func collectPerfMetrics(wg *sync.WaitGroup, e *Exporter, f *find.Finder, datacenterName string, ch chan<- prometheus.Metric) {
+	defer wg.Done()
+	if len(perfCounters) == 0 {
+		return
+	}
+
+	refNames := make(map[string]string)
+	var refs []types.ManagedObjectReference
+
+	if hosts, err := f.HostSystemList(e.context, "*"); err == nil {
+		for _, h := range hosts {
+			if !hostFilter.allowed(h.InventoryPath) {
+				continue
+			}
+			refs = append(refs, h.Reference())
+			refNames[h.Reference().Value] = h.Name()
+		}
+	}
+	if datastores, err := f.DatastoreList(e.context, "*"); err == nil {
+		for _, d := range datastores {
+			if !datastoreFilter.allowed(d.InventoryPath) {
+				continue
+			}
+			refs = append(refs, d.Reference())
+			refNames[d.Reference().Value] = d.Name()
+		}
+	}
+	if vms, err := f.VirtualMachineList(e.context, "*"); err == nil {
+		for _, vm := range vms {
+			if !vmFilter.allowed(vm.InventoryPath) {
+				continue
+			}
+			refs = append(refs, vm.Reference())
+			refNames[vm.Reference().Value] = vm.Name()
+		}
+	}
+	if len(refs) == 0 {
+		return
+	}
+
+	pm := performance.NewManager(e.client.Client)
+	counterInfo, err := e.perfCounterInfo(pm)
+	if err != nil {
+		log.Infoln("Could not retrieve perf counter info: ", err)
+		return
+	}
+
+	var metricIDs []types.PerfMetricId
+	counterByID := make(map[int32]*types.PerfCounterInfo)
+	for _, req := range perfCounters {
+		info, ok := counterInfo[req.name]
+		if !ok {
+			log.Infoln("Unknown perf counter requested: ", req.name)
+			continue
+		}
+		metricIDs = append(metricIDs, types.PerfMetricId{CounterId: info.Key, Instance: req.instance})
+		counterByID[info.Key] = info
+	}
+	if len(metricIDs) == 0 {
+		return
+	}
+
+	var querySpecs []types.PerfQuerySpec
+	for _, ref := range refs {
+		querySpecs = append(querySpecs, types.PerfQuerySpec{
+			Entity:     ref,
+			MetricId:   metricIDs,
+			IntervalId: 20,
+			MaxSample:  1,
+		})
+	}
+
+	result, err := pm.Query(e.context, querySpecs)
+	if err != nil {
+		log.Infoln("Could not query performance counters: ", err)
+		return
+	}
+
+	for _, base := range result {
+		entityMetric, ok := base.(*types.PerfEntityMetric)
+		if !ok {
+			continue
+		}
+		entityName := refNames[entityMetric.Entity.Value]
+		for _, s := range entityMetric.Value {
+			series, ok := s.(*types.PerfMetricIntSeries)
+			if !ok || len(series.Value) == 0 {
+				continue
+			}
+			info, ok := counterByID[series.Id.CounterId]
+			if !ok {
+				continue
+			}
+			value := convertPerfValue(info, series.Value[len(series.Value)-1])
+			labelNames := append([]string{"name", "datacenter", "instance", "vcenter"}, e.extraLabelKeys...)
+			desc := prometheus.NewDesc(namespace+"_"+perfMetricPrefix+perfMetricName(info), "vCenter real-time performance counter "+perfCounterFullName(info), labelNames, nil)
+			labelValues := append([]string{entityName, datacenterName, series.Id.Instance, e.config.Name}, e.extraLabelValues...)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+		}
+	}
+}
+
+//perfMetricName turns a counter's "group.name.rollup" identity into a Prometheus-safe metric name
+func perfMetricName(info *types.PerfCounterInfo) string {
+	group := info.GroupInfo.GetElementDescription().Key
+	name := info.NameInfo.GetElementDescription().Key
+	rollup := strings.ToLower(string(info.RollupType))
+	return strings.Replace(strings.ToLower(group+"_"+name+"_"+rollup), ".", "_", -1)
+}
+
+func perfCounterFullName(info *types.PerfCounterInfo) string {
+	return info.GroupInfo.GetElementDescription().Key + "." + info.NameInfo.GetElementDescription().Key + "." + strings.ToLower(string(info.RollupType))
+}
+
+//convertPerfValue translates a raw counter sample into its Prometheus base unit, following the
+//conventions counters declare via UnitInfo (KBps -> bytes/sec, percent -> ratio, microseconds/
+//milliseconds -> seconds).
+func convertPerfValue(info *types.PerfCounterInfo, raw int64) float64 {
+	value := float64(raw)
+	if info.UnitInfo == nil {
+		return value
+	}
+	switch info.UnitInfo.GetElementDescription().Key {
+	case "kiloBytesPerSecond", "kiloBytes":
+		return value * 1024
+	case "percent":
+		//percent counters are reported in hundredths of a percent (10000 = 100%)
+		return value / 10000
+	case "microsecond":
+		return value / 1e6
+	case "millisecond":
+		return value / 1000
+	default:
+		return value
+	}
+}
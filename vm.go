@@ -0,0 +1,263 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/property"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type vsphereVMMetrics []*vsphereVMMetric
+
+type vsphereVMMetric struct {
+	desc         *prometheus.Desc
+	metricGetter vmMetricGetter
+	labelsGetter []vmLabelGetter
+}
+
+type vmMetricGetter func(mo.VirtualMachine) float64
+type vmLabelGetter func(mo.VirtualMachine) string
+
+//Labels associated with the VM objects
+var vmLabelNames = []string{"name", "datacenter", "cluster", "host", "resource_pool", "folder", "vcenter"}
+
+//Array of anonymous functions to retrieve label values
+var vmLabelValues = []vmLabelGetter{vmLabelGetterFuncRegistry["getVMName"]}
+
+//Map of anonymous functions to retrieve label values from a VM object
+var vmLabelGetterFuncRegistry = map[string]vmLabelGetter{
+	"getVMName": func(vm mo.VirtualMachine) string { return vm.Summary.Config.Name },
+}
+
+//Map of anonymous functions to retrieve metric values
+var vmMetricGetterFuncRegistry = map[string]vmMetricGetter{
+	"getPoweredOnState": func(vm mo.VirtualMachine) float64 {
+		if state := vm.Summary.Runtime.PowerState; state == types.VirtualMachinePowerStatePoweredOn {
+			return 1
+		}
+		return 0
+	},
+	"getPoweredOffState": func(vm mo.VirtualMachine) float64 {
+		if state := vm.Summary.Runtime.PowerState; state == types.VirtualMachinePowerStatePoweredOff {
+			return 1
+		}
+		return 0
+	},
+	"getSuspendedState": func(vm mo.VirtualMachine) float64 {
+		if state := vm.Summary.Runtime.PowerState; state == types.VirtualMachinePowerStateSuspended {
+			return 1
+		}
+		return 0
+	},
+	"getToolsRunning": func(vm mo.VirtualMachine) float64 {
+		if vm.Summary.Guest == nil {
+			return 0
+		}
+		if status := vm.Summary.Guest.ToolsStatus; status == types.VirtualMachineToolsStatusToolsOk || status == types.VirtualMachineToolsStatusToolsOld {
+			return 1
+		}
+		return 0
+	},
+	"getNumCpu":           func(vm mo.VirtualMachine) float64 { return float64(vm.Summary.Config.NumCpu) },
+	"getMemorySizeMB":     func(vm mo.VirtualMachine) float64 { return float64(vm.Summary.Config.MemorySizeMB) },
+	"getOverallCpuUsage":  func(vm mo.VirtualMachine) float64 { return float64(vm.Summary.QuickStats.OverallCpuUsage) },
+	"getGuestMemoryUsage": func(vm mo.VirtualMachine) float64 { return float64(vm.Summary.QuickStats.GuestMemoryUsage) },
+	"getHostMemoryUsage":  func(vm mo.VirtualMachine) float64 { return float64(vm.Summary.QuickStats.HostMemoryUsage) },
+	"getStorageCommitted": func(vm mo.VirtualMachine) float64 {
+		if vm.Summary.Storage == nil {
+			return 0
+		}
+		return float64(vm.Summary.Storage.Committed)
+	},
+	"getStorageUncommitted": func(vm mo.VirtualMachine) float64 {
+		if vm.Summary.Storage == nil {
+			return 0
+		}
+		return float64(vm.Summary.Storage.Uncommitted)
+	},
+	"getStorageUnshared": func(vm mo.VirtualMachine) float64 {
+		if vm.Summary.Storage == nil {
+			return 0
+		}
+		return float64(vm.Summary.Storage.Unshared)
+	},
+	"getUptimeSeconds": func(vm mo.VirtualMachine) float64 { return float64(vm.Summary.QuickStats.UptimeSeconds) },
+}
+
+func newVsphereVMMetric(name string, description string, labels []string, metricGetter vmMetricGetter, labelsGetter []vmLabelGetter) *vsphereVMMetric {
+	return &vsphereVMMetric{
+		desc:         prometheus.NewDesc(name, description, labels, nil),
+		metricGetter: metricGetter,
+		labelsGetter: labelsGetter,
+	}
+}
+
+//buildVMMetrics defines all collected metrics for VirtualMachines. It is built per-Exporter, rather
+//than once at package init, so that a target's extra_labels are reflected in every Desc's label set.
+func buildVMMetrics(extraLabelKeys []string) vsphereVMMetrics {
+	labelNames := append(append([]string{}, vmLabelNames...), extraLabelKeys...)
+	return vsphereVMMetrics{
+		newVsphereVMMetric(vmMetricPrefix+"powered_on_state", "VM powered on state", labelNames, vmMetricGetterFuncRegistry["getPoweredOnState"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"powered_off_state", "VM powered off state", labelNames, vmMetricGetterFuncRegistry["getPoweredOffState"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"suspended_state", "VM suspended state", labelNames, vmMetricGetterFuncRegistry["getSuspendedState"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"tools_running_state", "VM guest tools running state", labelNames, vmMetricGetterFuncRegistry["getToolsRunning"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"num_cpu", "Number of virtual CPUs configured on the VM", labelNames, vmMetricGetterFuncRegistry["getNumCpu"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"memory_size_mb", "Memory configured on the VM", labelNames, vmMetricGetterFuncRegistry["getMemorySizeMB"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"cpu_usage_mhz", "VM overall CPU usage", labelNames, vmMetricGetterFuncRegistry["getOverallCpuUsage"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"guest_memory_usage_mb", "Guest memory usage as reported by VMware Tools", labelNames, vmMetricGetterFuncRegistry["getGuestMemoryUsage"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"host_memory_usage_mb", "Host physical memory consumed by the VM", labelNames, vmMetricGetterFuncRegistry["getHostMemoryUsage"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"storage_committed_bytes", "Storage committed to the VM", labelNames, vmMetricGetterFuncRegistry["getStorageCommitted"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"storage_uncommitted_bytes", "Storage uncommitted to the VM", labelNames, vmMetricGetterFuncRegistry["getStorageUncommitted"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"storage_unshared_bytes", "Storage unshared by the VM", labelNames, vmMetricGetterFuncRegistry["getStorageUnshared"], vmLabelValues),
+		newVsphereVMMetric(vmMetricPrefix+"uptime_seconds", "VM uptime", labelNames, vmMetricGetterFuncRegistry["getUptimeSeconds"], vmLabelValues),
+	}
+}
+
+//hostClusterInfo caches the host/cluster names a VM is placed on
+type hostClusterInfo struct {
+	host    string
+	cluster string
+}
+
+func collectVMMetrics(wg *sync.WaitGroup, e *Exporter, f *find.Finder, datacenterName string, ch chan<- prometheus.Metric) {
+	defer wg.Done()
+	vms, err := f.VirtualMachineList(e.context, "*")
+	if err != nil {
+		log.Infoln("Could not retrieve VM list: %s", err)
+		return
+	}
+	if len(vms) == 0 {
+		return
+	}
+	var refs []types.ManagedObjectReference
+	for _, vm := range vms {
+		if !vmFilter.allowed(vm.InventoryPath) {
+			continue
+		}
+		refs = append(refs, vm.Reference())
+	}
+	pc := property.DefaultCollector(e.client.Client)
+	var vmList []mo.VirtualMachine
+	err = pc.Retrieve(e.context, refs, []string{"summary", "runtime", "resourcePool", "parent"}, &vmList)
+	if err != nil {
+		log.Infoln("Could not retrieve VM properties: ", err)
+		return
+	}
+
+	hostClusterNames := resolveHostClusterNames(e, vmList)
+	resourcePoolNames := resolveEntityNames(e, resourcePoolRefs(vmList))
+	folderNames := resolveEntityNames(e, folderRefs(vmList))
+
+	for _, vm := range vmList {
+		hostName, clusterName := "", ""
+		if vm.Runtime.Host != nil {
+			hostName = hostClusterNames[vm.Runtime.Host.Value].host
+			clusterName = hostClusterNames[vm.Runtime.Host.Value].cluster
+		}
+		resourcePoolName := ""
+		if vm.ResourcePool != nil {
+			resourcePoolName = resourcePoolNames[vm.ResourcePool.Value]
+		}
+		folderName := ""
+		if vm.Parent != nil {
+			folderName = folderNames[vm.Parent.Value]
+		}
+		for _, metric := range e.vmMetrics {
+			var labelValues []string
+			for _, labelGetter := range metric.labelsGetter {
+				labelValues = append(labelValues, labelGetter(vm))
+			}
+			labelValues = append(labelValues, datacenterName, clusterName, hostName, resourcePoolName, folderName, e.config.Name)
+			labelValues = append(labelValues, e.extraLabelValues...)
+			ch <- prometheus.MustNewConstMetric(metric.desc, prometheus.GaugeValue, metric.metricGetter(vm), labelValues...)
+		}
+	}
+}
+
+//resolveHostClusterNames batches a single property collector call per unique host reference
+//instead of one per VM, then resolves each host's parent cluster name the same way.
+func resolveHostClusterNames(e *Exporter, vmList []mo.VirtualMachine) map[string]hostClusterInfo {
+	info := make(map[string]hostClusterInfo)
+	var hostRefs []types.ManagedObjectReference
+	seen := make(map[string]bool)
+	for _, vm := range vmList {
+		if vm.Runtime.Host != nil && !seen[vm.Runtime.Host.Value] {
+			seen[vm.Runtime.Host.Value] = true
+			hostRefs = append(hostRefs, *vm.Runtime.Host)
+		}
+	}
+	if len(hostRefs) == 0 {
+		return info
+	}
+	pc := property.DefaultCollector(e.client.Client)
+	var hosts []mo.HostSystem
+	if err := pc.Retrieve(e.context, hostRefs, []string{"name", "parent"}, &hosts); err != nil {
+		log.Infoln("Could not resolve VM host/cluster names: ", err)
+		return info
+	}
+	var parentRefs []types.ManagedObjectReference
+	seenParent := make(map[string]bool)
+	for _, h := range hosts {
+		if h.Parent != nil && !seenParent[h.Parent.Value] {
+			seenParent[h.Parent.Value] = true
+			parentRefs = append(parentRefs, *h.Parent)
+		}
+	}
+	clusterNames := resolveEntityNames(e, parentRefs)
+	for _, h := range hosts {
+		clusterName := ""
+		if h.Parent != nil {
+			clusterName = clusterNames[h.Parent.Value]
+		}
+		info[h.Self.Value] = hostClusterInfo{host: h.Name, cluster: clusterName}
+	}
+	return info
+}
+
+func resourcePoolRefs(vmList []mo.VirtualMachine) []types.ManagedObjectReference {
+	var refs []types.ManagedObjectReference
+	seen := make(map[string]bool)
+	for _, vm := range vmList {
+		if vm.ResourcePool != nil && !seen[vm.ResourcePool.Value] {
+			seen[vm.ResourcePool.Value] = true
+			refs = append(refs, *vm.ResourcePool)
+		}
+	}
+	return refs
+}
+
+func folderRefs(vmList []mo.VirtualMachine) []types.ManagedObjectReference {
+	var refs []types.ManagedObjectReference
+	seen := make(map[string]bool)
+	for _, vm := range vmList {
+		if vm.Parent != nil && !seen[vm.Parent.Value] {
+			seen[vm.Parent.Value] = true
+			refs = append(refs, *vm.Parent)
+		}
+	}
+	return refs
+}
+
+//resolveEntityNames looks up the "name" property for an arbitrary set of managed object
+//references in a single batched call, used for resource pools and folders alike.
+func resolveEntityNames(e *Exporter, refs []types.ManagedObjectReference) map[string]string {
+	names := make(map[string]string)
+	if len(refs) == 0 {
+		return names
+	}
+	pc := property.DefaultCollector(e.client.Client)
+	var entities []mo.ManagedEntity
+	if err := pc.Retrieve(e.context, refs, []string{"name"}, &entities); err != nil {
+		log.Infoln("Could not resolve object names: ", err)
+		return names
+	}
+	for _, entity := range entities {
+		names[entity.Self.Value] = entity.Name
+	}
+	return names
+}
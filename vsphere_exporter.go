@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
@@ -20,71 +22,139 @@ const (
 	namespace                 = "vsphere"
 	vmwareDatastoreObjectName = "Datastore"
 	vmwareHostObjectName      = "HostSystem"
+	vmwareVMObjectName        = "VirtualMachine"
 	esxiMetricPrefix          = "esxi_"
 	datastoreMetricPrefix     = "datastore_"
+	vmMetricPrefix            = "vm_"
+	clusterMetricPrefix       = "cluster_"
 )
 
 var (
-
-	//Defines all collected metrics for ESXI HostSystem
-	hostMetrics = vsphereHostMetrics{
-		newVsphereHostMetric(esxiMetricPrefix+"memory_total_bytes", "Size of the esxi memory", hostLabelNames, hostMetricGetterFuncRegistry["getMemorySize"], hostLabelValues),
-		newVsphereHostMetric(esxiMetricPrefix+"memory_usage_bytes", "Memory usage of the ESXi host", hostLabelNames, hostMetricGetterFuncRegistry["getMemoryUsage"], hostLabelValues),
-		newVsphereHostMetric(esxiMetricPrefix+"cpu_total_mhz", "Total cpu available", hostLabelNames, hostMetricGetterFuncRegistry["getCPUTotal"], hostLabelValues),
-		newVsphereHostMetric(esxiMetricPrefix+"cpu_usage_mhz", "CPU usage", hostLabelNames, hostMetricGetterFuncRegistry["getCpuUsage"], hostLabelValues),
-		newVsphereHostMetric(esxiMetricPrefix+"connected_state", "Esxi host connected state", hostLabelNames, hostMetricGetterFuncRegistry["getConnectedState"], hostLabelValues),
-		newVsphereHostMetric(esxiMetricPrefix+"disconnected_state", "Esxi host connected state", hostLabelNames, hostMetricGetterFuncRegistry["getDisconnectedState"], hostLabelValues),
-		newVsphereHostMetric(esxiMetricPrefix+"not_responding_state", "Esxi host connected state", hostLabelNames, hostMetricGetterFuncRegistry["getNotRespondingState"], hostLabelValues),
-	}
-
-	//Defines all collected metrics for Datastores
-	datastoreMetrics = vsphereDatastoreMetrics{
-		newVsphereDatastoreMetric(datastoreMetricPrefix+"capacity_bytes", "Datastore capacity", datastoreLabelNames, datastoreMetricGetterFuncRegistry["getCapacity"], datastoreLabelValues),
-		newVsphereDatastoreMetric(datastoreMetricPrefix+"free_space_bytes", "Datastore free space", datastoreLabelNames, datastoreMetricGetterFuncRegistry["getFreeSpace"], datastoreLabelValues),
-		newVsphereDatastoreMetric(datastoreMetricPrefix+"accessibility", "Datastore connectivity status", datastoreLabelNames, datastoreMetricGetterFuncRegistry["getAccessibility"], datastoreLabelValues),
-	}
+	upDesc               = prometheus.NewDesc(namespace+"_up", "Was the last scrape of this vCenter's metrics successful", []string{"vcenter"}, nil)
+	vcenterAvailableDesc = prometheus.NewDesc("vcenter_available", "Set to 1 if the vcenter is available", []string{"vcenter"}, nil)
 )
 
+//Exporter scrapes a single vCenter. Each configured target gets its own Exporter and its own
+//govmomi session, so a dead connection on one vCenter only takes down its own metrics.
 type Exporter struct {
 	context          context.Context
-	client           govmomi.Client
+	config           VCenterConfig
+	mu               sync.Mutex
+	client           *govmomi.Client
 	hostView         view.ContainerView
 	datastoreView    view.ContainerView
-	up               prometheus.Gauge
+	vmView           view.ContainerView
 	vcenterAvailable float64
+	perfCache        perfCounterCache
+
+	//hostMetrics, datastoreMetrics, vmMetrics and clusterMetrics are built once from config, since
+	//metric_prefix, the metrics.host/metrics.datastore selection and extra_labels are per-target
+	//rather than global.
+	hostMetrics      vsphereHostMetrics
+	datastoreMetrics vsphereDatastoreMetrics
+	vmMetrics        vsphereVMMetrics
+	clusterMetrics   vsphereClusterMetrics
+
+	//Cluster-level reservation metrics, sourced from the cluster's root resource pool runtime stats
+	//rather than the per-metric registry used for the rest of the cluster metrics. Built per-Exporter
+	//like clusterMetrics so extra_labels is reflected in their Desc.
+	clusterCPUReservationDesc    *prometheus.Desc
+	clusterMemoryReservationDesc *prometheus.Desc
+
+	//extraLabelKeys and extraLabelValues hold the extraLabelKeys union passed to NewExporter and
+	//config.ExtraLabels[key] for each of those keys, in the same order, so every target's metrics
+	//share one label schema. extraLabelKeys is also needed by collectPerfMetrics, whose Desc is built
+	//per series rather than cached like the other metric builders.
+	extraLabelKeys   []string
+	extraLabelValues []string
 }
 
-func NewExporter(vcenterUrl string, username string, password string, insecure bool) (*Exporter, error) {
-	u, err := url.Parse(fmt.Sprintf("https://%s:%s@%s/sdk", username, password, vcenterUrl))
-	ctx, _ := context.WithCancel(context.Background())
-	c, err := govmomi.NewClient(ctx, u, insecure)
+//NewExporter builds an Exporter for a single vCenter target. extraLabelKeys is the union of
+//extra_labels keys across every configured target: every Exporter's host/datastore metrics must
+//share the same label set, so a key one target doesn't define is reported as an empty string rather
+//than being omitted.
+func NewExporter(config VCenterConfig, extraLabelKeys []string) (*Exporter, error) {
+	nameGetter := newHostNameLabelGetter(config.Domain, config.RemoveHostDomainName)
+	hostMetrics, err := buildHostMetrics(config.MetricPrefix, config.Metrics.Host, nameGetter, extraLabelKeys)
 	if err != nil {
-		log.Infoln("Unable to connect to the vCenter")
-		log.Fatal(err)
+		return nil, err
+	}
+	datastoreMetrics, err := buildDatastoreMetrics(config.MetricPrefix, config.Metrics.Datastore, extraLabelKeys)
+	if err != nil {
+		return nil, err
+	}
+	vmMetrics := buildVMMetrics(extraLabelKeys)
+	clusterMetrics := buildClusterMetrics(extraLabelKeys)
+
+	resourcePoolLabelNames := append(append([]string{}, clusterResourcePoolLabelNames...), extraLabelKeys...)
+	clusterCPUReservationDesc := prometheus.NewDesc(clusterMetricPrefix+"cpu_reservation_mhz", "CPU reserved for VMs in the cluster's root resource pool", resourcePoolLabelNames, nil)
+	clusterMemoryReservationDesc := prometheus.NewDesc(clusterMetricPrefix+"memory_reservation_bytes", "Memory reserved for VMs in the cluster's root resource pool", resourcePoolLabelNames, nil)
+
+	extraLabelValues := make([]string, len(extraLabelKeys))
+	for i, key := range extraLabelKeys {
+		extraLabelValues[i] = config.ExtraLabels[key]
+	}
+	return &Exporter{
+		context:                      context.Background(),
+		config:                       config,
+		hostMetrics:                  hostMetrics,
+		datastoreMetrics:             datastoreMetrics,
+		vmMetrics:                    vmMetrics,
+		clusterMetrics:               clusterMetrics,
+		clusterCPUReservationDesc:    clusterCPUReservationDesc,
+		clusterMemoryReservationDesc: clusterMemoryReservationDesc,
+		extraLabelKeys:               extraLabelKeys,
+		extraLabelValues:             extraLabelValues,
+	}, nil
+}
+
+//ensureConnected (re)establishes the govmomi session if needed. It is called lazily from Collect
+//rather than at construction time, so a vCenter that is down when the exporter starts (or goes
+//stale between scrapes) doesn't bring the whole process down.
+func (e *Exporter) ensureConnected() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		active, err := e.client.SessionManager.SessionIsActive(e.context)
+		if err == nil && active {
+			return nil
+		}
+		log.Infoln("vCenter session for", e.config.Name, "is no longer active, reconnecting")
+		e.client = nil
+	}
+
+	u, err := url.Parse(fmt.Sprintf("https://%s:%s@%s/sdk", e.config.Username, e.config.Password, e.config.URL))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(e.context, 30*time.Second)
+	defer cancel()
+	c, err := govmomi.NewClient(ctx, u, e.config.Insecure)
+	if err != nil {
+		return err
 	}
-	log.Infoln("Connected to vCenter")
+	log.Infoln("Connected to vCenter", e.config.Name)
+
 	manager := view.NewManager(c.Client)
-	datastoreContainerView, err := manager.CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{vmwareDatastoreObjectName}, true)
+	datastoreContainerView, err := manager.CreateContainerView(e.context, c.ServiceContent.RootFolder, []string{vmwareDatastoreObjectName}, true)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	hostContainerView, err := manager.CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{vmwareHostObjectName}, true)
+	hostContainerView, err := manager.CreateContainerView(e.context, c.ServiceContent.RootFolder, []string{vmwareHostObjectName}, true)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	vmContainerView, err := manager.CreateContainerView(e.context, c.ServiceContent.RootFolder, []string{vmwareVMObjectName}, true)
+	if err != nil {
+		return err
 	}
 
-	return &Exporter{
-		context:       ctx,
-		client:        *c,
-		hostView:      *hostContainerView,
-		datastoreView: *datastoreContainerView,
-		up: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      "up",
-			Help:      "Was the last scrape of vCenter metrics success",
-		}),
-		vcenterAvailable: 1,
-	}, nil
+	e.client = c
+	e.hostView = *hostContainerView
+	e.datastoreView = *datastoreContainerView
+	e.vmView = *vmContainerView
+	return nil
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
@@ -92,55 +162,157 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	ch <- e.up
-	f := find.NewFinder(e.client.Client, true)
-	datacenters, err := f.DatacenterList(e.context, "*")
+	if err := e.ensureConnected(); err != nil {
+		log.Infoln("Could not connect to vCenter", e.config.Name, ":", err)
+		e.vcenterAvailable = 0
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0, e.config.Name)
+		ch <- prometheus.MustNewConstMetric(vcenterAvailableDesc, prometheus.GaugeValue, 0, e.config.Name)
+		return
+	}
+
+	datacenters, err := discoverDatacenters(e.context, e.client.Client)
 	if err != nil {
-		log.Infoln("Could not retrieve Datacenters list : %s", err)
+		log.Infoln("Could not retrieve Datacenters list for", e.config.Name, ":", err)
 		e.vcenterAvailable = 0
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0, e.config.Name)
+		ch <- prometheus.MustNewConstMetric(vcenterAvailableDesc, prometheus.GaugeValue, 0, e.config.Name)
 		return
 	}
 	e.vcenterAvailable = 1
 	var wg sync.WaitGroup
-	//We need to wait the metrics for 2 objects (datastore+hosts) per datacenter
-	wg.Add(2 * len(datacenters))
+	//We need to wait the metrics for 5 objects (datastore+hosts+VMs+perf counters+clusters) per datacenter
+	wg.Add(5 * len(datacenters))
 	for _, dc := range datacenters {
+		//Each datacenter gets its own Finder: Finder.SetDatacenter just assigns f.dc, and the 5
+		//goroutines below run concurrently with the next iteration of this loop, so sharing one
+		//Finder across datacenters is a data race that silently scopes searches to the wrong
+		//datacenter instead of erroring.
+		f := find.NewFinder(e.client.Client, true)
 		f.SetDatacenter(dc)
 		//Host data retrieval
 		go collectHostMetrics(&wg, e, f, dc.Name(), ch)
 		//Datastore data retrieval
-		go collectDatastoreMetrics(&wg, e, dc.Name(), ch)
+		go collectDatastoreMetrics(&wg, e, f, dc.Name(), ch)
+		//VM data retrieval
+		go collectVMMetrics(&wg, e, f, dc.Name(), ch)
+		//Real-time performance counters, opt-in via --perf.counters
+		go collectPerfMetrics(&wg, e, f, dc.Name(), ch)
+		//Cluster-level aggregate data retrieval
+		go collectClusterMetrics(&wg, e, f, dc.Name(), ch)
 
 	}
 	wg.Wait()
 
-	vcenterAvailableDesc := prometheus.NewDesc("vcenter_available", "Set to 1 if the vcenter is available", []string{}, nil)
-	ch <- prometheus.MustNewConstMetric(vcenterAvailableDesc, prometheus.GaugeValue, e.vcenterAvailable)
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1, e.config.Name)
+	ch <- prometheus.MustNewConstMetric(vcenterAvailableDesc, prometheus.GaugeValue, e.vcenterAvailable, e.config.Name)
+}
+
+//MultiExporter fans a single Prometheus scrape out to every configured vCenter target in parallel.
+type MultiExporter struct {
+	exporters []*Exporter
+}
+
+func NewMultiExporter(config *Config) (*MultiExporter, error) {
+	extraLabelKeys := extraLabelKeyUnion(config.VCenters)
+	me := &MultiExporter{}
+	for _, vc := range config.VCenters {
+		e, err := NewExporter(vc, extraLabelKeys)
+		if err != nil {
+			return nil, fmt.Errorf("vcenter %q: %s", vc.Name, err)
+		}
+		me.exporters = append(me.exporters, e)
+	}
+	return me, nil
+}
+
+//extraLabelKeyUnion collects every extra_labels key configured across all targets, sorted for a
+//deterministic label order. Every target's host/datastore Desc must declare the same label names,
+//so a target that doesn't set a given key still reports it as an empty string.
+func extraLabelKeyUnion(vcenters []VCenterConfig) []string {
+	seen := make(map[string]bool)
+	for _, vc := range vcenters {
+		for key := range vc.ExtraLabels {
+			seen[key] = true
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *MultiExporter) Describe(ch chan<- *prometheus.Desc) {
+
+}
+
+func (m *MultiExporter) Collect(ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	wg.Add(len(m.exporters))
+	for _, e := range m.exporters {
+		go func(e *Exporter) {
+			defer wg.Done()
+			e.Collect(ch)
+		}(e)
+	}
+	wg.Wait()
 }
 
 func main() {
 	var (
-		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9102").String()
-		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-		vcenterUrl    = kingpin.Flag("vcenterUrl", "URL of the vCenter.").Default("localhost").String()
-		username      = kingpin.Flag("username", "Username to connect the vCenter.").String()
-		password      = kingpin.Flag("password", "Password to connect the vCenter").String()
-		insecure      = kingpin.Flag("insecure", "Flag that enables SSL certificate verification.").Default("true").Bool()
+		listenAddress   = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9102").String()
+		metricsPath     = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		vcenterUrl      = kingpin.Flag("vcenterUrl", "URL of the vCenter.").Default("localhost").String()
+		username        = kingpin.Flag("username", "Username to connect the vCenter.").String()
+		password        = kingpin.Flag("password", "Password to connect the vCenter").String()
+		insecure        = kingpin.Flag("insecure", "Flag that enables SSL certificate verification.").Default("true").Bool()
+		configFile      = kingpin.Flag("config.file", "Path to a YAML/JSON config file listing vCenter targets. Overrides --vcenterUrl/--username/--password/--insecure.").String()
+		perfCounterFlag = kingpin.Flag("perf.counters", "Real-time performance counter to collect via QueryPerf, e.g. cpu.usage.average. Suffix with :aggregate (e.g. cpu.usage.average:aggregate) to collect a single aggregate series instead of a per-device breakdown. Repeatable; none collected by default.").Strings()
+
+		hostInclude      = kingpin.Flag("host.include", "Inventory path glob (* single segment, ** recursive) a host must match to be scraped. Repeatable; unset means all hosts.").Strings()
+		hostExclude      = kingpin.Flag("host.exclude", "Inventory path glob a host must not match to be scraped. Repeatable; wins over --host.include.").Strings()
+		datastoreInclude = kingpin.Flag("datastore.include", "Inventory path glob a datastore must match to be scraped. Repeatable; unset means all datastores.").Strings()
+		datastoreExclude = kingpin.Flag("datastore.exclude", "Inventory path glob a datastore must not match to be scraped. Repeatable; wins over --datastore.include.").Strings()
+		vmInclude        = kingpin.Flag("vm.include", "Inventory path glob a VM must match to be scraped. Repeatable; unset means all VMs.").Strings()
+		vmExclude        = kingpin.Flag("vm.exclude", "Inventory path glob a VM must not match to be scraped. Repeatable; wins over --vm.include.").Strings()
 	)
 
 	kingpin.Version(version.Print("vsphere_exporter"))
 	kingpin.Parse()
 
+	perfCounters = parsePerfCounterRequests(*perfCounterFlag)
+	hostFilter = newInventoryFilter(*hostInclude, *hostExclude)
+	datastoreFilter = newInventoryFilter(*datastoreInclude, *datastoreExclude)
+	vmFilter = newInventoryFilter(*vmInclude, *vmExclude)
+
 	log.Infoln("Starting vsphere_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	e, err := NewExporter(*vcenterUrl, *username, *password, *insecure)
+	var config *Config
+	if *configFile != "" {
+		c, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config = c
+	} else {
+		config = &Config{VCenters: []VCenterConfig{{
+			Name:     *vcenterUrl,
+			URL:      *vcenterUrl,
+			Username: *username,
+			Password: *password,
+			Insecure: *insecure,
+		}}}
+	}
+
+	me, err := NewMultiExporter(config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	//Register to prometheus
-	prometheus.MustRegister(e)
+	prometheus.MustRegister(me)
 
 	log.Infoln("Listening on", *listenAddress)
 	http.Handle(*metricsPath, prometheus.Handler())